@@ -12,22 +12,38 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
+// bootstrapSamples is the number of resamples used to estimate a 95%
+// confidence interval around cookDay's point estimate.
+const bootstrapSamples = 1000
+
 //go:generate go run loader.go
 
-type run struct {
-	opsSec     float64
-	readBytes  int64
-	writeBytes int64
-	readAmp    float64
-	writeAmp   float64
+// metricOpsSec is the one metric cookDay treats specially: it gets a
+// robust point estimate plus a bootstrapped confidence interval rather
+// than a plain mean.
+const metricOpsSec = "opsSec"
+
+// reportMetricAliases maps the label names b.ReportMetric produces to the
+// names this file already aggregates under; unknown labels pass through
+// unchanged.
+var reportMetricAliases = map[string]string{
+	"read-bytes":  "readBytes",
+	"write-bytes": "writeBytes",
+	"r-amp":       "r-amp",
+	"w-amp":       "w-amp",
 }
 
+// run is one benchmark sample: an arbitrary set of named metrics.
+type run map[string]float64
+
 type workload struct {
 	days map[string][]run // data -> runs
 }
@@ -58,14 +74,9 @@ func (l *loader) load(path string) {
 			continue
 		}
 
-		var r run
-		var name string
-		var ops int64
-		n, err := fmt.Sscanf(line,
-			"Benchmark%s %d %f ops/sec %d read %d write %f r-amp %f w-amp",
-			&name, &ops, &r.opsSec, &r.readBytes, &r.writeBytes, &r.readAmp, &r.writeAmp)
-		if err != nil || n != 7 {
-			fmt.Fprintf(os.Stderr, "%s: %v\n", s.Text(), err)
+		name, r, ok := parseLine(line)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: unrecognized benchmark line\n", line)
 			continue
 		}
 
@@ -78,6 +89,110 @@ func (l *loader) load(path string) {
 	}
 }
 
+// parseLine parses one benchmark output line, auto-detecting whether it's
+// in Pebble's own format or the canonical `go test -bench` format.
+func parseLine(line string) (name string, r run, ok bool) {
+	if strings.Contains(line, "ops/sec") {
+		return parseLegacyLine(line)
+	}
+	return parseGoTestLine(line)
+}
+
+// parseLegacyLine parses the Pebble-specific line shape this loader has
+// always accepted:
+//
+//	Benchmark<name> <N> <opsSec> ops/sec <readBytes> read <writeBytes> write <readAmp> r-amp <writeAmp> w-amp
+func parseLegacyLine(line string) (name string, r run, ok bool) {
+	var ops int64
+	var opsSec, readBytes, writeBytes, readAmp, writeAmp float64
+	n, err := fmt.Sscanf(line,
+		"Benchmark%s %d %f ops/sec %f read %f write %f r-amp %f w-amp",
+		&name, &ops, &opsSec, &readBytes, &writeBytes, &readAmp, &writeAmp)
+	if err != nil || n != 7 {
+		return "", nil, false
+	}
+	return name, run{
+		metricOpsSec: opsSec,
+		"readBytes":  readBytes,
+		"writeBytes": writeBytes,
+		"r-amp":      readAmp,
+		"w-amp":      writeAmp,
+	}, true
+}
+
+// parseGoTestLine parses the canonical output of `go test -bench`, e.g.:
+//
+//	BenchmarkFoo-8   123   4567 ns/op   890 B/op   2 allocs/op   read-bytes=999 write-bytes=888
+//
+// ns/op is converted to the derived opsSec metric, and B/op and allocs/op
+// are stored as bytesPerOp and allocsPerOp. Every other "<value>
+// <unit>" pair and "name=value" label (as produced by b.ReportMetric) is
+// kept under its own name (remapped through reportMetricAliases when one
+// applies), so arbitrary metrics flow through without this loader needing
+// to know about them in advance.
+func parseGoTestLine(line string) (name string, r run, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return "", nil, false
+	}
+
+	name = strings.TrimPrefix(fields[0], "Benchmark")
+	if i := strings.LastIndexByte(name, '-'); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+
+	// fields[1] is the iteration count; everything after it is either a
+	// "<value> <unit>" pair or a "name=value" label.
+	rest := fields[2:]
+	r = make(run)
+	for i := 0; i < len(rest); {
+		tok := rest[i]
+		if eq := strings.IndexByte(tok, '='); eq >= 0 {
+			key, val := tok[:eq], tok[eq+1:]
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				if alias, ok := reportMetricAliases[key]; ok {
+					key = alias
+				}
+				r[key] = f
+			}
+			i++
+			continue
+		}
+
+		if i+1 >= len(rest) {
+			break
+		}
+		val, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			i++
+			continue
+		}
+		unit := rest[i+1]
+		switch unit {
+		case "ns/op":
+			if val > 0 {
+				r[metricOpsSec] = 1e9 / val
+			}
+		case "B/op":
+			r["bytesPerOp"] = val
+		case "allocs/op":
+			r["allocsPerOp"] = val
+		default:
+			if alias, ok := reportMetricAliases[unit]; ok {
+				unit = alias
+			}
+			r[unit] = val
+		}
+		i += 2
+	}
+	if len(r) == 0 {
+		return "", nil, false
+	}
+	return name, r, true
+}
+
 func (l *loader) cook() map[string]string {
 	m := make(map[string]string)
 	for name, workload := range l.data {
@@ -93,51 +208,167 @@ func (l *loader) cookWorkload(w *workload) string {
 	}
 	sort.Strings(days)
 
+	names := metricNames(w.days)
+
 	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "day,%s\n", strings.Join(header(names), ","))
 	for _, day := range days {
-		fmt.Fprintf(&buf, "%s,%s\n", day, l.cookDay(w.days[day]))
+		fmt.Fprintf(&buf, "%s,%s\n", day, l.cookDay(w.days[day], names))
 	}
 	return buf.String()
 }
 
-func (l *loader) cookDay(runs []run) string {
-	var sum float64
-	for i := range runs {
-		sum += runs[i].opsSec
+// metricNames returns the sorted union of metric names seen across all of
+// a workload's runs, with metricOpsSec always first: it's the metric
+// every line format produces and the one cookDay treats specially.
+func metricNames(days map[string][]run) []string {
+	seen := make(map[string]bool)
+	for _, runs := range days {
+		for _, r := range runs {
+			for name := range r {
+				seen[name] = true
+			}
+		}
 	}
-	mean := sum / float64(len(runs))
+	delete(seen, metricOpsSec)
 
-	var sum2 float64
-	for i := range runs {
-		v := runs[i].opsSec - mean
-		sum2 += v * v
+	names := make([]string, 0, len(seen)+1)
+	names = append(names, metricOpsSec)
+	for name := range seen {
+		names = append(names, name)
 	}
+	sort.Strings(names[1:])
+	return names
+}
 
-	stddev := math.Sqrt(sum2 / float64(len(runs)))
-	lo := mean - stddev
-	hi := mean + stddev
+// header expands metric names into their cooked CSV column names;
+// metricOpsSec becomes its point estimate plus the two CI bounds.
+func header(names []string) []string {
+	cols := make([]string, 0, len(names)+2)
+	for _, name := range names {
+		if name == metricOpsSec {
+			cols = append(cols, "opsSec", "opsSecLo", "opsSecHi")
+			continue
+		}
+		cols = append(cols, name)
+	}
+	return cols
+}
 
-	var avg run
-	var count int
-	for i := range runs {
-		r := &runs[i]
-		if r.opsSec < lo || r.opsSec > hi {
+// cookDay reduces a day's samples to a single CSV row, one column (or
+// three, for metricOpsSec) per entry in names. metricOpsSec gets a robust
+// central tendency (the benchstat approach: the median for small n, a
+// Tukey-trimmed mean discarding the top and bottom 25% once n>=8) plus a
+// 95% confidence interval obtained by bootstrapping the day's runs; with
+// fewer than 3 samples there isn't enough data to bootstrap, so the CI
+// columns are left empty. Every other metric is a plain mean over the
+// runs that reported it — they aren't noisy enough on their own to
+// warrant the same treatment.
+func (l *loader) cookDay(runs []run, names []string) string {
+	cols := make([]string, 0, len(names)+2)
+	for _, name := range names {
+		samples := valuesFor(runs, name)
+		if name == metricOpsSec {
+			point := robustCentral(samples)
+			lo, hi := "", ""
+			if len(samples) >= 3 {
+				ciLo, ciHi := bootstrapCI(samples)
+				lo, hi = fmt.Sprintf("%.1f", ciLo), fmt.Sprintf("%.1f", ciHi)
+			}
+			cols = append(cols, fmt.Sprintf("%.1f", point), lo, hi)
 			continue
 		}
-		count++
-		avg.opsSec += r.opsSec
-		avg.readBytes += r.readBytes
-		avg.writeBytes += r.writeBytes
-		avg.readAmp += r.readAmp
-		avg.writeAmp += r.writeAmp
+		cols = append(cols, fmt.Sprintf("%.1f", mean(samples)))
 	}
+	return strings.Join(cols, ",")
+}
 
-	return fmt.Sprintf("%.1f,%d,%d,%.1f,%.1f",
-		avg.opsSec/float64(count),
-		avg.readBytes/int64(count),
-		avg.writeBytes/int64(count),
-		avg.readAmp/float64(count),
-		avg.writeAmp/float64(count))
+// valuesFor collects the values of metric name across runs, skipping runs
+// that didn't report it.
+func valuesFor(runs []run, name string) []float64 {
+	vals := make([]float64, 0, len(runs))
+	for _, r := range runs {
+		if v, ok := r[name]; ok {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range xs {
+		sum += v
+	}
+	return sum / float64(len(xs))
+}
+
+// robustCentral returns a robust point estimate of xs: the median when
+// there are too few samples to trim, otherwise the mean after discarding
+// the top and bottom 25% (the Tukey trimmed mean benchstat uses).
+func robustCentral(xs []float64) float64 {
+	n := len(xs)
+	if n == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	if n < 8 {
+		return median(sorted)
+	}
+
+	trim := n / 4
+	trimmed := sorted[trim : n-trim]
+	var sum float64
+	for _, v := range trimmed {
+		sum += v
+	}
+	return sum / float64(len(trimmed))
+}
+
+// median returns the median of a sorted slice.
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// bootstrapCI estimates a 95% confidence interval for robustCentral(xs) by
+// resampling xs with replacement bootstrapSamples times and taking the
+// 2.5th/97.5th percentiles of the resulting distribution of estimates.
+func bootstrapCI(xs []float64) (lo, hi float64) {
+	n := len(xs)
+	estimates := make([]float64, bootstrapSamples)
+	resample := make([]float64, n)
+	for i := range estimates {
+		for j := range resample {
+			resample[j] = xs[rand.Intn(n)]
+		}
+		estimates[i] = robustCentral(resample)
+	}
+	sort.Float64s(estimates)
+	return percentile(estimates, 0.025), percentile(estimates, 0.975)
+}
+
+// percentile linearly interpolates the p-th percentile (0<=p<=1) of a
+// sorted slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	idx := p * float64(len(sorted)-1)
+	lo := int(math.Floor(idx))
+	hi := int(math.Ceil(idx))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := idx - float64(lo)
+	return sorted[lo]*(1-frac) + sorted[hi]*frac
 }
 
 func prettyJSON(v interface{}) []byte {
@@ -149,6 +380,155 @@ func prettyJSON(v interface{}) []byte {
 	return data
 }
 
+const (
+	// trailingWindowDays is how much history a day is compared against.
+	trailingWindowDays = 14
+	// minDaySamples is the fewest samples a day needs to count towards the
+	// trailing window's baseline; noisier days are skipped rather than
+	// letting a single run skew the baseline.
+	minDaySamples = 2
+	// minWindowDays is the fewest qualifying days the trailing window
+	// needs before a day is eligible for regression detection at all.
+	minWindowDays = 2
+	// zScoreThreshold is the number of trailing-window standard
+	// deviations a day's value must move before it's flagged.
+	zScoreThreshold = 3.0
+)
+
+// higherIsBetter records, per metric, whether an increase is an
+// improvement (more ops/sec) or a regression (more bytes, more
+// amplification). Metrics absent from this map default to "lower is
+// better", which holds for every amplification/byte/latency-style metric
+// this loader has seen.
+var higherIsBetter = map[string]bool{
+	metricOpsSec: true,
+}
+
+// regression is one day where a workload's metric moved suspiciously
+// relative to its own recent history.
+type regression struct {
+	Workload  string  `json:"workload"`
+	Metric    string  `json:"metric"`
+	Day       string  `json:"day"`
+	Baseline  float64 `json:"baseline"`
+	Value     float64 `json:"value"`
+	Direction string  `json:"direction"` // "regression" or "improvement"
+}
+
+// detectRegressions walks l's time series looking for days that moved
+// suspiciously relative to their own recent history, ordered by workload
+// name, then metric (in metricNames order, so opsSec comes first), then day.
+func detectRegressions(l *loader) []regression {
+	names := make([]string, 0, len(l.data))
+	for name := range l.data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]regression, 0)
+	for _, name := range names {
+		out = append(out, detectWorkloadRegressions(name, l.data[name])...)
+	}
+	return out
+}
+
+// detectWorkloadRegressions applies the z-score and confidence-interval
+// tests to every (day, metric) pair in w with at least minDaySamples
+// samples and at least minWindowDays qualifying days among the previous
+// trailingWindowDays days. The trailing window is built from each prior day's own
+// robust point estimate (not its raw samples), so a day with an unusually
+// large run count can't outweigh its neighbors in the baseline.
+func detectWorkloadRegressions(name string, w *workload) []regression {
+	days := make([]string, 0, len(w.days))
+	for day := range w.days {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	out := make([]regression, 0)
+	for _, metric := range metricNames(w.days) {
+		for i, day := range days {
+			todaySamples := valuesFor(w.days[day], metric)
+			if len(todaySamples) < minDaySamples {
+				continue
+			}
+
+			start := i - trailingWindowDays
+			if start < 0 {
+				start = 0
+			}
+
+			var window []float64
+			for _, prior := range days[start:i] {
+				samples := valuesFor(w.days[prior], metric)
+				if len(samples) < minDaySamples {
+					continue
+				}
+				window = append(window, robustCentral(samples))
+			}
+			if len(window) < minWindowDays {
+				continue
+			}
+
+			baseline := mean(window)
+			value := robustCentral(todaySamples)
+			sigma := stddev(window, baseline)
+			var flagged bool
+			if sigma > 0 {
+				flagged = math.Abs(value-baseline) > zScoreThreshold*sigma
+			} else {
+				// A dead-flat window has no z-score to compare against;
+				// any deviation from it at all is suspicious.
+				flagged = value != baseline
+			}
+
+			if !flagged && len(todaySamples) >= 3 && len(window) >= 3 {
+				valueLo, valueHi := bootstrapCI(todaySamples)
+				windowLo, windowHi := bootstrapCI(window)
+				flagged = valueHi < windowLo || windowHi < valueLo
+			}
+			if !flagged {
+				continue
+			}
+
+			direction := "regression"
+			if improved(metric, value, baseline) {
+				direction = "improvement"
+			}
+			out = append(out, regression{
+				Workload:  name,
+				Metric:    metric,
+				Day:       day,
+				Baseline:  baseline,
+				Value:     value,
+				Direction: direction,
+			})
+		}
+	}
+	return out
+}
+
+// improved reports whether value is better than baseline for metric.
+func improved(metric string, value, baseline float64) bool {
+	if higherIsBetter[metric] {
+		return value > baseline
+	}
+	return value < baseline
+}
+
+// stddev returns the population standard deviation of xs around mean.
+func stddev(xs []float64, mean float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	var sum2 float64
+	for _, v := range xs {
+		d := v - mean
+		sum2 += d * d
+	}
+	return math.Sqrt(sum2 / float64(len(xs)))
+}
+
 func main() {
 	const dir = "data"
 	l := &loader{data: make(map[string]*workload)}
@@ -165,4 +545,12 @@ func main() {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
 		os.Exit(1)
 	}
+
+	const regressionsOutput = "regressions.json"
+	regressions := detectRegressions(l)
+	err = ioutil.WriteFile(regressionsOutput, prettyJSON(regressions), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,224 @@
+// Copyright 2020 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRobustCentral(t *testing.T) {
+	tests := []struct {
+		name string
+		xs   []float64
+		want float64
+	}{
+		{"n=1", []float64{5}, 5},
+		{"n=2 median", []float64{4, 2}, 3},
+		{"n=3 median", []float64{3, 1, 2}, 2},
+		{"n=7 still median", []float64{7, 1, 2, 6, 3, 5, 4}, 4},
+		{"n=8 trimmed mean", []float64{1, 2, 3, 4, 5, 6, 7, 8}, 4.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := robustCentral(tt.xs); got != tt.want {
+				t.Errorf("robustCentral(%v) = %v, want %v", tt.xs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootstrapCI(t *testing.T) {
+	xs := []float64{10, 12, 11, 13, 9, 14, 10, 12}
+	lo, hi := bootstrapCI(xs)
+	if lo > hi {
+		t.Fatalf("lo (%v) > hi (%v)", lo, hi)
+	}
+
+	min, max := xs[0], xs[0]
+	for _, v := range xs {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if lo < min || hi > max {
+		t.Errorf("CI [%v, %v] escapes sample range [%v, %v]", lo, hi, min, max)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{0.5, 3},
+		{1, 5},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(sorted, %v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestParseLineFormatDetection(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"legacy", "BenchmarkFoo 100 12345.6 ops/sec 999 read 888 write 1.1 r-amp 2.2 w-amp"},
+		{"go test -bench", "BenchmarkFoo-8 100 1000000 ns/op 890 B/op 2 allocs/op"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, r, ok := parseLine(tt.line)
+			if !ok {
+				t.Fatalf("parseLine(%q) returned ok=false", tt.line)
+			}
+			if name != "Foo" {
+				t.Errorf("name = %q, want %q", name, "Foo")
+			}
+			if _, ok := r[metricOpsSec]; !ok {
+				t.Errorf("r = %v missing %s", r, metricOpsSec)
+			}
+		})
+	}
+}
+
+func TestParseLegacyLine(t *testing.T) {
+	name, r, ok := parseLegacyLine(
+		"BenchmarkFoo 100 12345.6 ops/sec 999 read 888 write 1.1 r-amp 2.2 w-amp")
+	if !ok {
+		t.Fatal("parseLegacyLine returned ok=false")
+	}
+	if name != "Foo" {
+		t.Errorf("name = %q, want Foo", name)
+	}
+	want := run{
+		metricOpsSec: 12345.6,
+		"readBytes":  999,
+		"writeBytes": 888,
+		"r-amp":      1.1,
+		"w-amp":      2.2,
+	}
+	for k, v := range want {
+		if r[k] != v {
+			t.Errorf("r[%q] = %v, want %v", k, r[k], v)
+		}
+	}
+}
+
+func TestParseGoTestLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want run
+	}{
+		{
+			"ns/op only",
+			"BenchmarkFoo-8 100 1000000 ns/op",
+			run{metricOpsSec: 1000},
+		},
+		{
+			"B/op and allocs/op",
+			"BenchmarkFoo-8 100 1000000 ns/op 890 B/op 2 allocs/op",
+			run{metricOpsSec: 1000, "bytesPerOp": 890, "allocsPerOp": 2},
+		},
+		{
+			"ReportMetric value-unit pairs get aliased",
+			"BenchmarkFoo-8 100 1000000 ns/op 999 read-bytes 888 write-bytes 1.1 r-amp 2.2 w-amp",
+			run{metricOpsSec: 1000, "readBytes": 999, "writeBytes": 888, "r-amp": 1.1, "w-amp": 2.2},
+		},
+		{
+			"name=value labels get aliased",
+			"BenchmarkFoo-8 100 1000000 ns/op read-bytes=999 write-bytes=888",
+			run{metricOpsSec: 1000, "readBytes": 999, "writeBytes": 888},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, r, ok := parseGoTestLine(tt.line)
+			if !ok {
+				t.Fatalf("parseGoTestLine(%q) returned ok=false", tt.line)
+			}
+			if name != "Foo" {
+				t.Errorf("name = %q, want Foo", name)
+			}
+			if len(r) != len(tt.want) {
+				t.Errorf("r = %v, want exactly %v", r, tt.want)
+			}
+			for k, v := range tt.want {
+				if r[k] != v {
+					t.Errorf("r[%q] = %v, want %v", k, r[k], v)
+				}
+			}
+		})
+	}
+}
+
+// makeWorkload builds a workload from per-day opsSec samples, keyed by day.
+func makeWorkload(days map[string][]float64) *workload {
+	w := &workload{days: make(map[string][]run)}
+	for day, vals := range days {
+		for _, v := range vals {
+			w.days[day] = append(w.days[day], run{metricOpsSec: v})
+		}
+	}
+	return w
+}
+
+func TestDetectWorkloadRegressionsFlagsSpike(t *testing.T) {
+	days := make(map[string][]float64)
+	for i := 1; i <= 15; i++ {
+		days[fmt.Sprintf("day%02d", i)] = []float64{100, 101}
+	}
+	days["day16"] = []float64{1000, 1010} // far outside the trailing window
+
+	got := detectWorkloadRegressions("wl", makeWorkload(days))
+
+	var found bool
+	for _, r := range got {
+		if r.Day != "day16" {
+			t.Errorf("unexpected regression flagged: %+v", r)
+			continue
+		}
+		found = true
+		if r.Direction != "improvement" {
+			t.Errorf("direction = %q, want improvement", r.Direction)
+		}
+	}
+	if !found {
+		t.Errorf("expected day16 to be flagged, got %v", got)
+	}
+}
+
+func TestDetectWorkloadRegressionsRequiresWindow(t *testing.T) {
+	w := makeWorkload(map[string][]float64{
+		"day01": {100, 5000}, // wild, but it's the first day: no trailing window yet
+	})
+	if got := detectWorkloadRegressions("wl", w); len(got) != 0 {
+		t.Errorf("expected no regressions with no trailing window, got %v", got)
+	}
+}
+
+func TestDetectWorkloadRegressionsSkipsSingleSampleDays(t *testing.T) {
+	days := make(map[string][]float64)
+	for i := 1; i <= 5; i++ {
+		days[fmt.Sprintf("day%02d", i)] = []float64{100, 102}
+	}
+	days["day06"] = []float64{500} // a single sample isn't enough to trust
+
+	got := detectWorkloadRegressions("wl", makeWorkload(days))
+	for _, r := range got {
+		if r.Day == "day06" {
+			t.Errorf("day06 has only 1 sample and should have been skipped, got %+v", r)
+		}
+	}
+}